@@ -1,11 +1,20 @@
 package mapping
 
+import (
+	"regexp"
+	"strings"
+
+	"github.com/omniscale/imposm3/logging"
+)
+
+var log = logging.NewLogger("mapping")
+
 func (m *Mapping) NodeTagFilter() *TagFilter {
 	mappings := make(map[string]map[string][]string)
 	m.mappings("point", mappings)
 	tags := make(map[string]bool)
 	m.extraTags("point", tags)
-	return &TagFilter{mappings, tags}
+	return newTagFilter(mappings, tags)
 }
 
 func (m *Mapping) WayTagFilter() *TagFilter {
@@ -15,7 +24,7 @@ func (m *Mapping) WayTagFilter() *TagFilter {
 	tags := make(map[string]bool)
 	m.extraTags("linestring", tags)
 	m.extraTags("polygon", tags)
-	return &TagFilter{mappings, tags}
+	return newTagFilter(mappings, tags)
 }
 
 func (m *Mapping) RelationTagFilter() *RelationTagFilter {
@@ -26,18 +35,81 @@ func (m *Mapping) RelationTagFilter() *RelationTagFilter {
 	m.extraTags("linestring", tags)
 	m.extraTags("polygon", tags)
 	tags["type"] = true // do not filter out type tag
-	return &RelationTagFilter{TagFilter{mappings, tags}}
+	return &RelationTagFilter{*newTagFilter(mappings, tags)}
 }
 
 type TagFilter struct {
 	mappings  map[string]map[string][]string
 	extraTags map[string]bool
+	// matchers holds the regex/negation predicates found in mappings,
+	// keyed by the same tag key. Exact values stay in mappings so the
+	// common case (direct map lookup) stays allocation-free; matchers is
+	// only consulted once an exact lookup misses.
+	matchers map[string]*valueMatcher
 }
 
 type RelationTagFilter struct {
 	TagFilter
 }
 
+// valuePredicate is a single non-exact value matcher: either a compiled
+// regex (mapping value "~<regex>") or a negated literal (mapping value
+// "!<value>", matching every value except <value>).
+type valuePredicate struct {
+	regex  *regexp.Regexp
+	negate string
+}
+
+func (p valuePredicate) match(v string) bool {
+	if p.regex != nil {
+		return p.regex.MatchString(v)
+	}
+	return v != p.negate
+}
+
+type valueMatcher struct {
+	predicates []valuePredicate
+}
+
+func (m *valueMatcher) match(v string) bool {
+	for _, p := range m.predicates {
+		if p.match(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// newTagFilter builds a TagFilter from the raw value sets collected by
+// (*Mapping).mappings, pulling any "~regex" or "!negation" entries out
+// into a precompiled valueMatcher so Filter does not need to interpret
+// them on every call.
+func newTagFilter(mappings map[string]map[string][]string, extraTags map[string]bool) *TagFilter {
+	matchers := make(map[string]*valueMatcher)
+	for k, values := range mappings {
+		var predicates []valuePredicate
+		for v := range values {
+			switch {
+			case strings.HasPrefix(v, "~"):
+				re, err := regexp.Compile(v[1:])
+				if err != nil {
+					log.Warn("invalid regexp in mapping value for ", k, ": ", v, ": ", err)
+					continue
+				}
+				predicates = append(predicates, valuePredicate{regex: re})
+				delete(values, v)
+			case strings.HasPrefix(v, "!"):
+				predicates = append(predicates, valuePredicate{negate: v[1:]})
+				delete(values, v)
+			}
+		}
+		if len(predicates) > 0 {
+			matchers[k] = &valueMatcher{predicates: predicates}
+		}
+	}
+	return &TagFilter{mappings, extraTags, matchers}
+}
+
 func (f *TagFilter) Filter(tags map[string]string) bool {
 	foundMapping := false
 	for k, v := range tags {
@@ -49,6 +121,9 @@ func (f *TagFilter) Filter(tags map[string]string) bool {
 			} else if _, ok := values[v]; ok {
 				foundMapping = true
 				continue
+			} else if matcher, ok := f.matchers[k]; ok && matcher.match(v) {
+				foundMapping = true
+				continue
 			} else if _, ok := f.extraTags[k]; !ok {
 				delete(tags, k)
 			}
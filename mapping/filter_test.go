@@ -0,0 +1,72 @@
+package mapping
+
+import "testing"
+
+func TestTagFilterRegexAndNegation(t *testing.T) {
+	mappings := map[string]map[string][]string{
+		"highway": {
+			"~^.*_link$": {"highway_link"},
+			"primary":    {"highway_primary"},
+		},
+		"building": {
+			"!no": {"building"},
+		},
+	}
+	f := newTagFilter(mappings, map[string]bool{})
+
+	tags := map[string]string{"highway": "motorway_link"}
+	if !f.Filter(tags) {
+		t.Fatal("expected regex match on highway=motorway_link")
+	}
+
+	tags = map[string]string{"highway": "footway"}
+	if f.Filter(tags) {
+		t.Fatal("expected no match on highway=footway")
+	}
+	if _, ok := tags["highway"]; ok {
+		t.Fatal("expected unmatched tag to be deleted")
+	}
+
+	tags = map[string]string{"building": "yes"}
+	if !f.Filter(tags) {
+		t.Fatal("expected negation match on building=yes")
+	}
+
+	tags = map[string]string{"building": "no"}
+	if f.Filter(tags) {
+		t.Fatal("expected negation to reject building=no")
+	}
+}
+
+func TestTagFilterExtraTagsKept(t *testing.T) {
+	mappings := map[string]map[string][]string{
+		"highway": {"primary": {"highway_primary"}},
+	}
+	extraTags := map[string]bool{"name": true}
+	f := newTagFilter(mappings, extraTags)
+
+	tags := map[string]string{"highway": "primary", "name": "Main St", "source": "survey"}
+	if !f.Filter(tags) {
+		t.Fatal("expected mapping match")
+	}
+	if _, ok := tags["name"]; !ok {
+		t.Fatal("extraTags entry should be kept even without a mapping")
+	}
+	if _, ok := tags["source"]; ok {
+		t.Fatal("tag without mapping or extraTags entry should be deleted")
+	}
+}
+
+func TestRelationTagFilterRequiresType(t *testing.T) {
+	f := &RelationTagFilter{TagFilter: *newTagFilter(map[string]map[string][]string{}, map[string]bool{})}
+
+	if f.Filter(map[string]string{"building": "yes"}) {
+		t.Fatal("expected relation without type tag to be filtered out")
+	}
+	if !f.Filter(map[string]string{"type": "multipolygon"}) {
+		t.Fatal("expected relation with type=multipolygon to pass")
+	}
+	if f.Filter(map[string]string{"type": "route"}) {
+		t.Fatal("expected relation with unsupported type to be filtered out")
+	}
+}
@@ -0,0 +1,225 @@
+package parser
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+
+	"github.com/omniscale/imposm3/element"
+)
+
+// ParseAugmented parses an Overpass "augmented diff", which wraps each
+// changed element in <action type="create|modify|delete"> and, for
+// modifies, nested <old>/<new> elements holding the pre- and
+// post-change state. For a modify, the returned DiffElem carries the
+// new state as usual (Node/Way/Rel) with Old set to the pre-change
+// state. Ways in an augmented diff may resolve their <nd> references to
+// coordinates (<nd ref lat lon>); for each one found, a synthesized
+// Node DiffElem is emitted ahead of the way so a consumer can apply the
+// way without its own coordinate cache.
+func ParseAugmented(r io.Reader) (chan DiffElem, chan error) {
+	elems := make(chan DiffElem)
+	errc := make(chan error, 1)
+	go parseAugmented(r, elems, errc)
+	return elems, errc
+}
+
+func parseAugmented(r io.Reader, elems chan DiffElem, errc chan error) {
+	defer close(elems)
+	defer close(errc)
+
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	reader, err := sniffReader(r)
+	if err != nil {
+		errc <- err
+		return
+	}
+
+	decoder := xml.NewDecoder(reader)
+
+	var actionType string
+	var old, newElem *DiffElem
+	inOld := false
+
+NextToken:
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			errc <- err
+			return
+		}
+
+		switch tok := token.(type) {
+		case xml.StartElement:
+			switch tok.Name.Local {
+			case "action":
+				actionType = ""
+				for _, attr := range tok.Attr {
+					if attr.Name.Local == "type" {
+						actionType = attr.Value
+					}
+				}
+				old, newElem = nil, nil
+			case "old":
+				inOld = true
+			case "new":
+				inOld = false
+			case "node", "way", "relation":
+				elem, coords, err := decodeAugmentedElem(decoder, tok)
+				if err != nil {
+					errc <- err
+					return
+				}
+				elem.Add = actionType == "create"
+				elem.Mod = actionType == "modify"
+				elem.Del = actionType == "delete"
+
+				if inOld {
+					old = &elem
+					continue NextToken
+				}
+
+				// Only the current (new/create) geometry reflects
+				// present-day coordinates; the old geometry of a
+				// modify is stale and must not overwrite it.
+				for _, coord := range coords {
+					elems <- coord
+				}
+				newElem = &elem
+			}
+		case xml.EndElement:
+			switch tok.Name.Local {
+			case "old":
+				inOld = false
+			case "action":
+				switch actionType {
+				case "delete":
+					if old != nil {
+						elems <- *old
+					} else if newElem != nil {
+						elems <- *newElem
+					}
+				default: // create, modify
+					if newElem != nil {
+						newElem.Old = old
+						elems <- *newElem
+					}
+				}
+				actionType = ""
+				old, newElem = nil, nil
+			}
+		}
+	}
+}
+
+// decodeAugmentedElem parses a single <node>/<way>/<relation> element,
+// including its tag/nd/member children, whether found directly under
+// <action> (create/delete) or inside <old>/<new> (modify). It reuses the
+// same attribute parsing as the plain OSC parser and Decoder. Alongside
+// the parsed element it returns a synthesized Node DiffElem for every
+// <nd> child that carries resolved lat/lon coordinates.
+func decodeAugmentedElem(decoder *xml.Decoder, start xml.StartElement) (DiffElem, []DiffElem, error) {
+	var e DiffElem
+	tags := make(map[string]string)
+	var coords []DiffElem
+
+	name := start.Name.Local
+	node := &element.Node{}
+	way := &element.Way{}
+	rel := &element.Relation{}
+
+	switch name {
+	case "node":
+		parseNodeAttrs(node, start.Attr)
+		setElemMetadata(start.Attr, &node.OSMElem)
+	case "way":
+		parseWayAttrs(way, start.Attr)
+		setElemMetadata(start.Attr, &way.OSMElem)
+	case "relation":
+		parseRelationAttrs(rel, start.Attr)
+		setElemMetadata(start.Attr, &rel.OSMElem)
+	}
+
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return e, nil, err
+		}
+
+		switch tok := token.(type) {
+		case xml.StartElement:
+			depth++
+			switch tok.Name.Local {
+			case "nd":
+				parseNdRef(way, tok.Attr)
+				if coord := coordNode(tok.Attr); coord != nil {
+					// Resolved coordinates reflect current state, so
+					// mark them Add/Mod (an upsert) regardless of the
+					// enclosing action -- there is no "old" coordinate
+					// DiffElem, so Del never applies here.
+					coords = append(coords, DiffElem{Node: coord, Add: true, Mod: true})
+				}
+			case "member":
+				if member, ok := parseMemberAttrs(tok.Attr); ok {
+					rel.Members = append(rel.Members, member)
+				}
+			case "tag":
+				k, v := parseTagAttrs(tok.Attr)
+				tags[k] = v
+			}
+		case xml.EndElement:
+			if depth == 0 {
+				switch name {
+				case "node":
+					if len(tags) > 0 {
+						node.Tags = tags
+					}
+					e.Node = node
+				case "way":
+					if len(tags) > 0 {
+						way.Tags = tags
+					}
+					e.Way = way
+				case "relation":
+					if len(tags) > 0 {
+						rel.Tags = tags
+					}
+					e.Rel = rel
+				}
+				return e, coords, nil
+			}
+			depth--
+		}
+	}
+}
+
+// coordNode builds a Node from a <nd> element's ref/lat/lon attributes,
+// or returns nil if it carries no resolved coordinates (a plain
+// unresolved <nd ref=".."/> as found in a regular OSC diff).
+func coordNode(attrs []xml.Attr) *element.Node {
+	node := &element.Node{}
+	hasCoord := false
+	for _, attr := range attrs {
+		switch attr.Name.Local {
+		case "ref":
+			node.Id, _ = strconv.ParseInt(attr.Value, 10, 64)
+		case "lat":
+			node.Lat, _ = strconv.ParseFloat(attr.Value, 64)
+			hasCoord = true
+		case "lon":
+			node.Long, _ = strconv.ParseFloat(attr.Value, 64)
+			hasCoord = true
+		}
+	}
+	if !hasCoord {
+		return nil
+	}
+	return node
+}
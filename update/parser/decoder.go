@@ -0,0 +1,297 @@
+package parser
+
+import (
+	"encoding/xml"
+	"io"
+	"sync"
+
+	"github.com/omniscale/imposm3/element"
+)
+
+// Decoder reads an osmChange document from an io.Reader and parses nodes,
+// ways and relations concurrently across a worker pool, modeled on
+// osmpbf.Decoder. The XML stream itself is walked by a single goroutine,
+// since an osmChange is inherently sequential, but the construction of
+// each element -- attribute parsing, tag map allocation, member/ref
+// slices, metadata timestamps -- is dispatched to the workers and
+// reassembled in original document order before being delivered through
+// Decode or Elems/Errors.
+type Decoder struct {
+	r io.Reader
+
+	// Metadata enables parsing of version/changeset/user/timestamp
+	// attributes, as ParseFull does for the sequential parser.
+	Metadata bool
+
+	elems chan DiffElem
+	errc  chan error
+
+	jobs    chan elemBatch
+	results chan elemResult
+}
+
+// elemBatch holds the raw tokens of a single <node>/<way>/<relation>
+// element, including its nested tag/nd/member children and the closing
+// tag, together with the add/mod/del context it was found in. Batches are
+// opaque to the producing goroutine; only a worker interprets them.
+type elemBatch struct {
+	seq           int64
+	name          string
+	add, mod, del bool
+	tokens        []xml.Token
+}
+
+type elemResult struct {
+	seq  int64
+	elem DiffElem
+	err  error
+}
+
+// NewDecoder creates a Decoder that reads an osmChange document from r.
+// Call Start to begin decoding.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		r:     r,
+		elems: make(chan DiffElem),
+		errc:  make(chan error, 1),
+	}
+}
+
+// Start begins decoding with n worker goroutines parsing elements
+// concurrently. It returns immediately; errors encountered while decoding
+// are delivered through Decode or Errors.
+func (d *Decoder) Start(n int) error {
+	if n < 1 {
+		n = 1
+	}
+	d.jobs = make(chan elemBatch, n)
+	d.results = make(chan elemResult, n)
+
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			for batch := range d.jobs {
+				elem, err := d.parseBatch(batch)
+				d.results <- elemResult{seq: batch.seq, elem: elem, err: err}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(d.results)
+	}()
+
+	go d.reorder()
+	go d.produce()
+
+	return nil
+}
+
+// Decode returns the next element in document order, or io.EOF once the
+// document is exhausted.
+func (d *Decoder) Decode() (DiffElem, error) {
+	elem, ok := <-d.elems
+	if ok {
+		return elem, nil
+	}
+	if err, ok := <-d.errc; ok && err != nil {
+		return DiffElem{}, err
+	}
+	return DiffElem{}, io.EOF
+}
+
+// Elems returns the channel of decoded elements, in document order, for
+// callers that prefer the channel-based API used by Parse/ParseFull.
+func (d *Decoder) Elems() chan DiffElem { return d.elems }
+
+// Errors returns the channel decoding errors are sent to.
+func (d *Decoder) Errors() chan error { return d.errc }
+
+// produce walks the XML stream sequentially, splitting it into
+// per-element token batches that are handed off to the worker pool.
+func (d *Decoder) produce() {
+	defer close(d.jobs)
+
+	decoder := xml.NewDecoder(d.r)
+	add, mod, del := false, false, false
+	var seq int64
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			d.errc <- err
+			return
+		}
+
+		switch tok := token.(type) {
+		case xml.StartElement:
+			switch tok.Name.Local {
+			case "create":
+				add, mod, del = true, false, false
+			case "modify":
+				add, mod, del = false, true, false
+			case "delete":
+				add, mod, del = false, false, true
+			case "node", "way", "relation":
+				tokens, err := collectElem(decoder, tok)
+				if err != nil {
+					d.errc <- err
+					return
+				}
+				d.jobs <- elemBatch{seq: seq, name: tok.Name.Local, add: add, mod: mod, del: del, tokens: tokens}
+				seq++
+			}
+		case xml.EndElement:
+			if tok.Name.Local == "osmChange" {
+				return
+			}
+		}
+	}
+}
+
+// collectElem gathers the start and end element tokens belonging to a
+// <node>/<way>/<relation> element, from its start tag up to and including
+// its matching end tag, so a worker can parse it without touching the
+// shared xml.Decoder. Character data, comments and other token kinds are
+// not kept.
+func collectElem(decoder *xml.Decoder, start xml.StartElement) ([]xml.Token, error) {
+	tokens := []xml.Token{start.Copy()}
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch tok := token.(type) {
+		case xml.StartElement:
+			depth++
+			tokens = append(tokens, tok.Copy())
+		case xml.EndElement:
+			if depth == 0 {
+				return append(tokens, tok), nil
+			}
+			depth--
+			tokens = append(tokens, tok)
+		}
+	}
+}
+
+// parseBatch turns a token batch into a DiffElem. It runs on a worker
+// goroutine and shares no state with the producer or other workers.
+func (d *Decoder) parseBatch(batch elemBatch) (DiffElem, error) {
+	var e DiffElem
+	tags := make(map[string]string)
+
+	switch batch.name {
+	case "node":
+		node := &element.Node{}
+		for _, tok := range batch.tokens {
+			start, ok := tok.(xml.StartElement)
+			if !ok {
+				continue
+			}
+			switch start.Name.Local {
+			case "node":
+				parseNodeAttrs(node, start.Attr)
+				if d.Metadata {
+					setElemMetadata(start.Attr, &node.OSMElem)
+				}
+			case "tag":
+				k, v := parseTagAttrs(start.Attr)
+				tags[k] = v
+			}
+		}
+		if len(tags) > 0 {
+			node.Tags = tags
+		}
+		e.Node = node
+	case "way":
+		way := &element.Way{}
+		for _, tok := range batch.tokens {
+			start, ok := tok.(xml.StartElement)
+			if !ok {
+				continue
+			}
+			switch start.Name.Local {
+			case "way":
+				parseWayAttrs(way, start.Attr)
+				if d.Metadata {
+					setElemMetadata(start.Attr, &way.OSMElem)
+				}
+			case "nd":
+				parseNdRef(way, start.Attr)
+			case "tag":
+				k, v := parseTagAttrs(start.Attr)
+				tags[k] = v
+			}
+		}
+		if len(tags) > 0 {
+			way.Tags = tags
+		}
+		e.Way = way
+	case "relation":
+		rel := &element.Relation{}
+		for _, tok := range batch.tokens {
+			start, ok := tok.(xml.StartElement)
+			if !ok {
+				continue
+			}
+			switch start.Name.Local {
+			case "relation":
+				parseRelationAttrs(rel, start.Attr)
+				if d.Metadata {
+					setElemMetadata(start.Attr, &rel.OSMElem)
+				}
+			case "member":
+				if member, ok := parseMemberAttrs(start.Attr); ok {
+					rel.Members = append(rel.Members, member)
+				}
+			case "tag":
+				k, v := parseTagAttrs(start.Attr)
+				tags[k] = v
+			}
+		}
+		if len(tags) > 0 {
+			rel.Tags = tags
+		}
+		e.Rel = rel
+	}
+
+	e.Add = batch.add
+	e.Mod = batch.mod
+	e.Del = batch.del
+	return e, nil
+}
+
+// reorder receives parsed elements as they complete, which may be out of
+// order, and emits them on elems in original document order using a
+// small buffer keyed by sequence number.
+func (d *Decoder) reorder() {
+	defer close(d.elems)
+	defer close(d.errc)
+
+	pending := make(map[int64]DiffElem)
+	var next int64
+
+	for res := range d.results {
+		if res.err != nil {
+			d.errc <- res.err
+			continue
+		}
+		pending[res.seq] = res.elem
+		for {
+			elem, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			d.elems <- elem
+			next++
+		}
+	}
+}
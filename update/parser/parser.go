@@ -1,8 +1,13 @@
 package parser
 
 import (
+	"bufio"
+	"compress/bzip2"
 	"compress/gzip"
 	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"strconv"
 	"time"
@@ -20,34 +25,104 @@ type DiffElem struct {
 	Node *element.Node
 	Way  *element.Way
 	Rel  *element.Relation
+
+	// Old holds the pre-modification element for "modify" actions parsed
+	// by ParseAugmented. It is always nil for plain OSC input and for
+	// add/delete actions.
+	Old *DiffElem
+}
+
+// Options controls how an osmChange document is parsed.
+type Options struct {
+	// Metadata parses version/changeset/user/timestamp attributes onto
+	// each element, as ParseFull does.
+	Metadata bool
 }
 
 func Parse(diff string) (chan DiffElem, chan error) {
+	return parseFile(diff, Options{})
+}
+
+func ParseFull(diff string) (chan DiffElem, chan error) {
+	return parseFile(diff, Options{Metadata: true})
+}
+
+func parseFile(diff string, opts Options) (chan DiffElem, chan error) {
 	elems := make(chan DiffElem)
-	errc := make(chan error)
-	go parse(diff, elems, errc, false)
+	errc := make(chan error, 1)
+
+	file, err := os.Open(diff)
+	if err != nil {
+		close(elems)
+		errc <- err
+		close(errc)
+		return elems, errc
+	}
+
+	go parse(file, elems, errc, opts.Metadata)
 	return elems, errc
 }
 
-func ParseFull(diff string) (chan DiffElem, chan error) {
+// ParseReader parses an osmChange document read from r. r may be plain,
+// gzip- or bzip2-compressed; the compression is detected from the first
+// bytes, so callers don't need to know the source's encoding up front.
+// If r also implements io.Closer, it is closed once parsing finishes.
+func ParseReader(r io.Reader, opts Options) (chan DiffElem, chan error) {
 	elems := make(chan DiffElem)
-	errc := make(chan error)
-	go parse(diff, elems, errc, true)
+	errc := make(chan error, 1)
+	go parse(r, elems, errc, opts.Metadata)
 	return elems, errc
 }
 
-func parse(diff string, elems chan DiffElem, errc chan error, metadata bool) {
+// ParseURL streams an osmChange document from a remote replication URL,
+// e.g. a minutely/hourly/daily diff from planet.openstreetmap.org,
+// without buffering it to a temporary file. The returned io.ReadCloser is
+// the HTTP response body; callers can close it to abort the download
+// (e.g. on cancellation), which also stops the parse and delivers an
+// error on errc.
+func ParseURL(url string, opts Options) (chan DiffElem, chan error, io.ReadCloser, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, nil, nil, fmt.Errorf("parser: GET %s: %s", url, resp.Status)
+	}
+
+	elems, errc := ParseReader(resp.Body, opts)
+	return elems, errc, resp.Body, nil
+}
+
+// sniffReader peeks at the first bytes of r to detect gzip or bzip2
+// compression and wraps r in the matching decompressor, or returns it
+// unchanged for plain .osc data.
+func sniffReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(3)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case len(magic) >= 2 && magic[0] == 0x1f && magic[1] == 0x8b:
+		return gzip.NewReader(br)
+	case len(magic) >= 3 && magic[0] == 'B' && magic[1] == 'Z' && magic[2] == 'h':
+		return bzip2.NewReader(br), nil
+	default:
+		return br, nil
+	}
+}
+
+func parse(r io.Reader, elems chan DiffElem, errc chan error, metadata bool) {
 	defer close(elems)
 	defer close(errc)
 
-	file, err := os.Open(diff)
-	if err != nil {
-		errc <- err
-		return
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
 	}
-	defer file.Close()
 
-	reader, err := gzip.NewReader(file)
+	reader, err := sniffReader(r)
 	if err != nil {
 		errc <- err
 		return
@@ -89,76 +164,31 @@ NextToken:
 				mod = false
 				del = true
 			case "node":
-				for _, attr := range tok.Attr {
-					switch attr.Name.Local {
-					case "id":
-						node.Id, _ = strconv.ParseInt(attr.Value, 10, 64)
-					case "lat":
-						node.Lat, _ = strconv.ParseFloat(attr.Value, 64)
-					case "lon":
-						node.Long, _ = strconv.ParseFloat(attr.Value, 64)
-					}
-				}
+				parseNodeAttrs(node, tok.Attr)
 				if metadata {
 					setElemMetadata(tok.Attr, &node.OSMElem)
 				}
 			case "way":
-				for _, attr := range tok.Attr {
-					if attr.Name.Local == "id" {
-						way.Id, _ = strconv.ParseInt(attr.Value, 10, 64)
-					}
-				}
+				parseWayAttrs(way, tok.Attr)
 				if metadata {
 					setElemMetadata(tok.Attr, &way.OSMElem)
 				}
 			case "relation":
-				for _, attr := range tok.Attr {
-					if attr.Name.Local == "id" {
-						rel.Id, _ = strconv.ParseInt(attr.Value, 10, 64)
-					}
-				}
+				parseRelationAttrs(rel, tok.Attr)
 				if metadata {
 					setElemMetadata(tok.Attr, &rel.OSMElem)
 				}
 			case "nd":
-				for _, attr := range tok.Attr {
-					if attr.Name.Local == "ref" {
-						ref, _ := strconv.ParseInt(attr.Value, 10, 64)
-						way.Refs = append(way.Refs, ref)
-					}
-				}
+				parseNdRef(way, tok.Attr)
 			case "member":
-				member := element.Member{}
-				for _, attr := range tok.Attr {
-					switch attr.Name.Local {
-					case "type":
-						var ok bool
-						member.Type, ok = element.MemberTypeValues[attr.Value]
-						if !ok {
-							// ignore unknown member types
-							continue NextToken
-						}
-					case "role":
-						member.Role = attr.Value
-					case "ref":
-						var err error
-						member.Id, err = strconv.ParseInt(attr.Value, 10, 64)
-						if err != nil {
-							// ignore invalid ref
-							continue NextToken
-						}
-					}
+				member, ok := parseMemberAttrs(tok.Attr)
+				if !ok {
+					// ignore unknown member type or invalid ref
+					continue NextToken
 				}
 				rel.Members = append(rel.Members, member)
 			case "tag":
-				var k, v string
-				for _, attr := range tok.Attr {
-					if attr.Name.Local == "k" {
-						k = attr.Value
-					} else if attr.Name.Local == "v" {
-						v = attr.Value
-					}
-				}
+				k, v := parseTagAttrs(tok.Attr)
 				tags[k] = v
 			case "osmChange":
 				// pass
@@ -226,4 +256,82 @@ func setElemMetadata(attrs []xml.Attr, elem *element.OSMElem) {
 			elem.Metadata.Timestamp, _ = time.Parse(time.RFC3339, attr.Value)
 		}
 	}
-}
\ No newline at end of file
+}
+
+// parseNodeAttrs reads id/lat/lon off a <node> start element. It is shared
+// by the sequential parser and the Decoder so both construct elements
+// identically.
+func parseNodeAttrs(node *element.Node, attrs []xml.Attr) {
+	for _, attr := range attrs {
+		switch attr.Name.Local {
+		case "id":
+			node.Id, _ = strconv.ParseInt(attr.Value, 10, 64)
+		case "lat":
+			node.Lat, _ = strconv.ParseFloat(attr.Value, 64)
+		case "lon":
+			node.Long, _ = strconv.ParseFloat(attr.Value, 64)
+		}
+	}
+}
+
+func parseWayAttrs(way *element.Way, attrs []xml.Attr) {
+	for _, attr := range attrs {
+		if attr.Name.Local == "id" {
+			way.Id, _ = strconv.ParseInt(attr.Value, 10, 64)
+		}
+	}
+}
+
+func parseRelationAttrs(rel *element.Relation, attrs []xml.Attr) {
+	for _, attr := range attrs {
+		if attr.Name.Local == "id" {
+			rel.Id, _ = strconv.ParseInt(attr.Value, 10, 64)
+		}
+	}
+}
+
+func parseNdRef(way *element.Way, attrs []xml.Attr) {
+	for _, attr := range attrs {
+		if attr.Name.Local == "ref" {
+			ref, _ := strconv.ParseInt(attr.Value, 10, 64)
+			way.Refs = append(way.Refs, ref)
+		}
+	}
+}
+
+// parseMemberAttrs builds a Member from a <member> start element. ok is
+// false if the member has an unknown type or an invalid ref and should be
+// skipped.
+func parseMemberAttrs(attrs []xml.Attr) (element.Member, bool) {
+	member := element.Member{}
+	for _, attr := range attrs {
+		switch attr.Name.Local {
+		case "type":
+			var ok bool
+			member.Type, ok = element.MemberTypeValues[attr.Value]
+			if !ok {
+				return element.Member{}, false
+			}
+		case "role":
+			member.Role = attr.Value
+		case "ref":
+			var err error
+			member.Id, err = strconv.ParseInt(attr.Value, 10, 64)
+			if err != nil {
+				return element.Member{}, false
+			}
+		}
+	}
+	return member, true
+}
+
+func parseTagAttrs(attrs []xml.Attr) (k, v string) {
+	for _, attr := range attrs {
+		if attr.Name.Local == "k" {
+			k = attr.Value
+		} else if attr.Name.Local == "v" {
+			v = attr.Value
+		}
+	}
+	return k, v
+}